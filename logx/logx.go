@@ -0,0 +1,60 @@
+// Package logx is a small leveled logger on top of the standard library
+// log package. Info and Warn always print; Debug output is gated per
+// category by the LYWSD_TRACE environment variable (e.g.
+// "LYWSD_TRACE=ble,notify" or "LYWSD_TRACE=all"), in the spirit of
+// syncthing's STTRACE. This keeps BLE handshake and notification noise out
+// of production logs while still being one env var away when needed.
+package logx
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Known debug categories.
+const (
+	BLE       = "ble"
+	Notify    = "notify"
+	DB        = "db"
+	Retention = "retention"
+	Scan      = "scan"
+)
+
+var enabled = parseTrace(os.Getenv("LYWSD_TRACE"))
+
+// parseTrace turns a comma-separated LYWSD_TRACE value into a lookup set.
+func parseTrace(v string) map[string]bool {
+	set := make(map[string]bool)
+	for _, cat := range strings.Split(v, ",") {
+		cat = strings.ToLower(strings.TrimSpace(cat))
+		if cat != "" {
+			set[cat] = true
+		}
+	}
+	return set
+}
+
+// Enabled reports whether category is turned on via LYWSD_TRACE, either by
+// name or via the catch-all "all".
+func Enabled(category string) bool {
+	return enabled["all"] || enabled[category]
+}
+
+// Debugf logs format/args under category, but only when that category (or
+// "all") is enabled via LYWSD_TRACE.
+func Debugf(category, format string, args ...interface{}) {
+	if Enabled(category) {
+		log.Printf("["+category+"] "+format, args...)
+	}
+}
+
+// Infof always logs format/args.
+func Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// Warnf always logs format/args, tagged so it stands out from Infof output.
+func Warnf(format string, args ...interface{}) {
+	log.Printf("WARN: "+format, args...)
+}
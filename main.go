@@ -3,48 +3,75 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/go-ble/ble"
-	"github.com/go-ble/ble/linux" // Linux-specific adapter
+	"github.com/syazwansaidan93/LYWSD03MMC_go/logx"
+	"github.com/syazwansaidan93/LYWSD03MMC_go/sensor"
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
 // Config represents the structure of config.json
 type Config struct {
-	MACAddresses      []string `json:"mac_addresses"`
-	PollIntervalMinutes int    `json:"poll_interval_minutes"`
+	MACAddresses              []string `json:"mac_addresses"`
+	PollIntervalMinutes       int      `json:"poll_interval_minutes"`
+	BatteryCheckIntervalHours int      `json:"battery_check_interval_hours,omitempty"`
+	Backend                   string   `json:"backend,omitempty"`
+	MetricsListen             string   `json:"metrics_listen,omitempty"`
 }
 
 const (
-	configFile                 = "config.json"
-	databaseName               = "sensor_data.db"
-	dataRetentionDays          = 1 // Changed from 7 to 1 day
-	connectionTimeoutSeconds   = 20 * time.Second
-	dataCharacteristicUUID     = "ebe0ccc1-7a0a-4b0c-8a1a-6ff2997da3a6"
-	scanTimeoutSeconds         = 20 * time.Second // Increased timeout for scanning
-	notificationWaitTimeoutSeconds = 30 * time.Second // Increased timeout for notifications
-	maxCollectionRetries       = 3
-	retryDelaySeconds          = 5 * time.Second
+	configFile                      = "config.json"
+	databaseName                    = "sensor_data.db"
+	dataRetentionDays               = 1 // Changed from 7 to 1 day
+	connectionTimeoutSeconds        = 20 * time.Second
+	scanTimeoutSeconds              = 20 * time.Second // Increased timeout for scanning
+	notificationWaitTimeoutSeconds  = 30 * time.Second // Increased timeout for notifications
+	maxCollectionRetries            = 3
+	retryDelaySeconds               = 5 * time.Second
+	defaultBatteryCheckInterval     = 24 * time.Hour
+	shutdownGracePeriod             = 15 * time.Second
 )
 
 var (
-	macToMonitor string
-	pollInterval time.Duration
-	dbPath       string
-	// lastSavedTime is not strictly needed as a global for the Go version
-	// but kept for conceptual parity, though its usage is localized.
-	lastSavedTime time.Time
+	macAddresses         []string
+	pollInterval         time.Duration
+	batteryCheckInterval time.Duration
+	dbPath               string
+	metricsListen        string
+
+	// configuredBackend is config.json's "backend" field, if set. It's
+	// sanity-checked against bleBackend.Name() in main, since exactly one
+	// backend implementation is compiled into any given binary.
+	configuredBackend string
+
+	// bleBackend is the single BLE backend instance shared by every
+	// per-device worker. It's responsible for serializing its own
+	// connect calls, since HCI on Linux is single-tenant.
+	bleBackend sensor.Backend
+
+	// batteryCache holds the last known battery/firmware reading per
+	// device so collectSingleReading only re-queries the Battery Service
+	// once per batteryCheckInterval, protecting the sensor's CR2032 cell.
+	batteryCacheMu sync.Mutex
+	batteryCache   = make(map[string]batteryCacheEntry)
 )
 
+// batteryCacheEntry is the last known battery/firmware reading for a device.
+type batteryCacheEntry struct {
+	level       int
+	firmware    string
+	lastChecked time.Time
+}
+
 func init() {
 	// Configure logging to stdout
 	log.SetOutput(os.Stdout)
@@ -78,14 +105,40 @@ func init() {
 	if len(configData.MACAddresses) == 0 {
 		log.Fatal("Error: No MAC addresses found in config.json.")
 	}
-	if len(configData.MACAddresses) > 1 {
-		log.Printf("Warning: config.json contains more than one MAC address. This script is optimized for a single sensor and will only process the first one found.")
-	}
 
-	macToMonitor = strings.ToUpper(configData.MACAddresses[0])
+	macAddresses = make([]string, len(configData.MACAddresses))
+	for i, mac := range configData.MACAddresses {
+		macAddresses[i] = strings.ToUpper(mac)
+	}
 	pollInterval = time.Duration(configData.PollIntervalMinutes) * time.Minute
 
-	lastSavedTime = time.Time{} // Initialize to zero time
+	batteryCheckInterval = defaultBatteryCheckInterval
+	if configData.BatteryCheckIntervalHours > 0 {
+		batteryCheckInterval = time.Duration(configData.BatteryCheckIntervalHours) * time.Hour
+	}
+
+	configuredBackend = configData.Backend
+	metricsListen = configData.MetricsListen
+
+	if err := checkTooShortInterval(len(macAddresses)); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// checkTooShortInterval refuses to start the daemon if the poll interval is
+// too short for every configured peripheral to be serviced at least once
+// per cycle, given the worst case of every attempt exhausting its scan
+// timeout and retry budget. Without this, a large sensor count (or a slow
+// adapter) would cause workers to fall permanently behind.
+func checkTooShortInterval(numPeripherals int) error {
+	worstCase := scanTimeoutSeconds * time.Duration(maxCollectionRetries) * time.Duration(numPeripherals)
+	if worstCase >= pollInterval {
+		return fmt.Errorf(
+			"poll_interval_minutes is too short: scanTimeout (%s) * readRetries (%d) * numPeripherals (%d) = %s, which is >= poll interval %s",
+			scanTimeoutSeconds, maxCollectionRetries, numPeripherals, worstCase, pollInterval,
+		)
+	}
+	return nil
 }
 
 // loadConfig reads and parses the config.json file.
@@ -123,248 +176,404 @@ func setupDatabase() {
 	CREATE TABLE IF NOT EXISTS sensor_readings (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		timestamp DATETIME NOT NULL,
+		mac TEXT NOT NULL DEFAULT '',
 		temperature REAL,
-		humidity INTEGER
+		humidity INTEGER,
+		battery INTEGER,
+		firmware TEXT
 	);`
 
-	createIndexSQL := `
+	createTimestampIndexSQL := `
 	CREATE INDEX IF NOT EXISTS idx_timestamp ON sensor_readings (timestamp);`
 
+	createMacIndexSQL := `
+	CREATE INDEX IF NOT EXISTS idx_mac_timestamp ON sensor_readings (mac, timestamp);`
+
 	_, err = conn.Exec(createTableSQL)
 	if err != nil {
 		log.Fatalf("Failed to create table: %v", err)
 	}
 
-	_, err = conn.Exec(createIndexSQL)
+	// Migration path for databases created before the mac column existed.
+	if err := addColumnIfMissing(conn, "sensor_readings", "mac", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatalf("Failed to migrate sensor_readings.mac column: %v", err)
+	}
+
+	// Migration path for databases created before battery/firmware were
+	// tracked. Both are nullable: most rows are temp/humidity-only and
+	// won't have a fresh battery read alongside them.
+	if err := addColumnIfMissing(conn, "sensor_readings", "battery", "INTEGER"); err != nil {
+		log.Fatalf("Failed to migrate sensor_readings.battery column: %v", err)
+	}
+	if err := addColumnIfMissing(conn, "sensor_readings", "firmware", "TEXT"); err != nil {
+		log.Fatalf("Failed to migrate sensor_readings.firmware column: %v", err)
+	}
+
+	_, err = conn.Exec(createTimestampIndexSQL)
+	if err != nil {
+		log.Fatalf("Failed to create timestamp index: %v", err)
+	}
+
+	_, err = conn.Exec(createMacIndexSQL)
+	if err != nil {
+		log.Fatalf("Failed to create mac/timestamp index: %v", err)
+	}
+	logx.Infof("Database setup complete.")
+}
+
+// addColumnIfMissing adds column to table with the given type/constraint
+// definition if it isn't already present, so existing databases created by
+// older versions of this daemon pick up new columns without losing data.
+func addColumnIfMissing(conn *sql.DB, table, column, definition string) error {
+	rows, err := conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		log.Fatalf("Failed to create index: %v", err)
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name, colType string
+		var notNull, pk int
+		var dfltValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to read table_info row for %s: %w", table, err)
+		}
+		if strings.EqualFold(name, column) {
+			return nil // Column already exists; nothing to migrate.
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
-	log.Println("Database setup complete.")
+
+	alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := conn.Exec(alterSQL); err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %w", column, table, err)
+	}
+	logx.Debugf(logx.DB, "Migrated %s: added column %s.", table, column)
+	return nil
 }
 
-// storeSensorData inserts temperature and humidity readings into the database.
-func storeSensorData(temperature float64, humidity int) {
+// storeSensorData inserts a temperature/humidity reading for macAddress into the database.
+// battery and firmware are nullable: they're only populated on cycles where
+// the Battery Service was actually re-queried (see refreshBatteryIfStale).
+func storeSensorData(ctx context.Context, macAddress string, temperature float64, humidity int, battery *int, firmware *string) {
 	conn, err := getDBConnection()
 	if err != nil {
-		log.Printf("Error getting DB connection for storing data: %v", err)
+		logx.Warnf("Error getting DB connection for storing data: %v", err)
 		return
 	}
 	defer conn.Close()
 
 	currentTime := time.Now()
-	_, err = conn.Exec(`
-		INSERT INTO sensor_readings (timestamp, temperature, humidity)
-		VALUES (?, ?, ?)
-	`, currentTime.Format("2006-01-02 15:04:05.000"), temperature, humidity) // SQLite stores DATETIME as text
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO sensor_readings (timestamp, mac, temperature, humidity, battery, firmware)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, currentTime.Format("2006-01-02 15:04:05.000"), macAddress, temperature, humidity, battery, firmware) // SQLite stores DATETIME as text
 
 	if err != nil {
-		log.Printf("Error storing data: %v", err)
-	} else {
-		lastSavedTime = currentTime
-		log.Printf("Saved data: T=%.2f°C, H=%d%% at %s.", temperature, humidity, currentTime.Format("2006-01-02 15:04:05"))
+		logx.Warnf("Error storing data for %s: %v", macAddress, err)
+		return
+	}
+
+	logx.Infof("Saved data for %s: T=%.2f°C, H=%d%% at %s.", macAddress, temperature, humidity, currentTime.Format("2006-01-02 15:04:05"))
+
+	temperatureGauge.WithLabelValues(macAddress).Set(temperature)
+	humidityGauge.WithLabelValues(macAddress).Set(float64(humidity))
+	if battery != nil {
+		batteryGauge.WithLabelValues(macAddress).Set(float64(*battery))
 	}
+	updateDBStatsGauges(ctx, conn)
 }
 
 // applyRetentionPolicy deletes old records from the database.
-func applyRetentionPolicy() {
+func applyRetentionPolicy(ctx context.Context) {
 	conn, err := getDBConnection()
 	if err != nil {
-		log.Printf("Error getting DB connection for retention policy: %v", err)
+		logx.Warnf("Error getting DB connection for retention policy: %v", err)
 		return
 	}
 	defer conn.Close()
 
 	thresholdTime := time.Now().Add(-time.Duration(dataRetentionDays) * 24 * time.Hour)
-	result, err := conn.Exec(`DELETE FROM sensor_readings WHERE timestamp < ?`, thresholdTime.Format("2006-01-02 15:04:05.000"))
+	result, err := conn.ExecContext(ctx, `DELETE FROM sensor_readings WHERE timestamp < ?`, thresholdTime.Format("2006-01-02 15:04:05.000"))
 	if err != nil {
-		log.Printf("Error applying retention policy: %v", err)
+		logx.Warnf("Error applying retention policy: %v", err)
 		return
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("Error getting rows affected by retention policy: %v", err)
+		logx.Warnf("Error getting rows affected by retention policy: %v", err)
 		return
 	}
 
 	if rowsAffected > 0 {
-		log.Printf("Applied retention policy: Deleted %d records older than %d days.", rowsAffected, dataRetentionDays)
+		logx.Infof("Applied retention policy: Deleted %d records older than %d days.", rowsAffected, dataRetentionDays)
+		retentionDeletesTotal.Add(float64(rowsAffected))
 	} else {
-		log.Printf("Retention policy ran: No data older than %d days to delete.", rowsAffected, dataRetentionDays)
+		logx.Debugf(logx.Retention, "Retention policy ran: No data older than %d days to delete.", dataRetentionDays)
 	}
+	updateDBStatsGauges(ctx, conn)
 }
 
-// collectSingleReading attempts to connect to the BLE device and collect a single reading.
-func collectSingleReading(macAddress string) (temperature float64, humidity int, err error) {
-	log.Printf("Attempting to connect to %s...", macAddress)
+// collectSingleReading attempts to connect to the BLE device and collect a
+// single reading. battery and firmware are non-nil only when the Battery
+// Service was actually re-queried this call (see shouldRefreshBattery). The
+// BLE specifics are delegated to bleBackend so this loop is backend-agnostic.
+func collectSingleReading(ctx context.Context, macAddress string) (temperature float64, humidity int, battery *int, firmware *string, err error) {
+	logx.Debugf(logx.BLE, "Attempting to connect to %s...", macAddress)
 
-	clientCtx, clientCancel := context.WithTimeout(context.Background(), connectionTimeoutSeconds)
-	defer clientCancel()
+	stopTimer := startCollectionTimer(macAddress)
+	defer stopTimer()
 
-	// Let ble.Connect handle the scanning and connection in one go.
-	// It will scan until it finds a device matching this filter and then connect.
-	cln, err := ble.Connect(clientCtx, func(a ble.Advertisement) bool {
-		// This filter will be used by ble.Connect to find the device.
-		// It will continue scanning until this function returns true for a device.
-		if strings.ToUpper(a.Addr().String()) == macAddress {
-			log.Printf("Device %s found during connection scan: %s", macAddress, a.LocalName())
-			return true
-		}
-		return false
-	})
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to connect to %s: %w", macAddress, err)
-	}
-	defer cln.CancelConnection() // Ensure disconnection on exit
-
-	log.Printf("Connected to %s. Discovering services...", macAddress)
+	clientCtx, clientCancel := context.WithTimeout(ctx, connectionTimeoutSeconds)
+	defer clientCancel()
 
-	// Discover all services
-	p, err := cln.DiscoverProfile(true)
+	session, err := bleBackend.Connect(clientCtx, macAddress)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to discover profile: %w", err)
+		return 0, 0, nil, nil, fmt.Errorf("failed to connect to %s: %w", macAddress, err)
 	}
-
-	var dataChar *ble.Characteristic
-	for _, s := range p.Services {
-		for _, c := range s.Characteristics {
-			if c.UUID.Equal(ble.MustParse(dataCharacteristicUUID)) {
-				dataChar = c
-				break
-			}
-		}
-		if dataChar != nil {
-			break
+	defer session.Close() // Ensure disconnection on exit
+
+	logx.Debugf(logx.BLE, "Connected to %s.", macAddress)
+
+	if shouldRefreshBattery(macAddress) {
+		if refreshErr := refreshBatteryAndFirmware(session, macAddress); refreshErr != nil {
+			logx.Warnf("Failed to refresh battery/firmware for %s: %v", macAddress, refreshErr)
+		} else if entry, ok := lastKnownBattery(macAddress); ok {
+			level := entry.level
+			fw := entry.firmware
+			battery = &level
+			firmware = &fw
 		}
 	}
 
-	if dataChar == nil {
-		return 0, 0, fmt.Errorf("data characteristic %s not found", dataCharacteristicUUID)
-	}
-
-	// New approach: The notification handler will store the data in a variable and signal a channel.
+	// The notification handler stores the data in a variable and signals a channel.
 	var collectedTemp float64
 	var collectedHumid int
-	var dataReady = make(chan struct{}) // Signal channel
-
-	notificationHandler := func(data []byte) {
-		log.Printf("Raw notification data received: %x (length: %d)", data, len(data)) // Log raw data
-		if len(data) >= 3 {
-			tempBytes := data[0:2]
-			collectedTemp = float64(int16(binary.LittleEndian.Uint16(tempBytes))) / 100.0
-			collectedHumid = int(data[2])
-			close(dataReady) // Signal that data is ready
-			log.Printf("Notification received and parsed: T=%.2f, H=%d", collectedTemp, collectedHumid)
-		} else {
-			log.Printf("Received malformed notification data (less than 3 bytes): %v", data)
-		}
+	var dataReadyOnce sync.Once
+	dataReady := make(chan struct{}) // Signal channel
+
+	err = session.SubscribeData(func(temp float64, humid int) {
+		collectedTemp = temp
+		collectedHumid = humid
+		dataReadyOnce.Do(func() { close(dataReady) }) // Signal that data is ready
+		logx.Debugf(logx.Notify, "Notification received and parsed: T=%.2f, H=%d", temp, humid)
+	})
+	if err != nil {
+		return 0, 0, nil, nil, fmt.Errorf("failed to subscribe to sensor data: %w", err)
 	}
 
-	// Subscribe with the corrected handler
-	err = cln.Subscribe(dataChar, true, notificationHandler)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to subscribe to characteristic %s: %w", dataCharacteristicUUID, err)
-	}
-
-	// --- NEW ADDITION: Try to read the characteristic once after subscribing ---
-	// This might be necessary for some sensors to "kickstart" notifications
-	// or to get an immediate value if the sensor doesn't notify immediately upon subscribe.
-	readVal, readErr := cln.ReadCharacteristic(dataChar)
-	if readErr == nil {
-		log.Printf("Successfully read characteristic %s: %x (length: %d)", dataCharacteristicUUID, readVal, len(readVal))
-		if len(readVal) >= 3 {
-			tempBytes := readVal[0:2]
-			readTemp := float64(int16(binary.LittleEndian.Uint16(tempBytes))) / 100.0
-			readHumid := int(readVal[2])
-			// If we get a valid read, use it as the collected data for this attempt
-			collectedTemp = readTemp
-			collectedHumid = readHumid
-			close(dataReady) // Signal that data is ready from the read
-			log.Printf("Read and parsed: T=%.2f, H=%d", collectedTemp, collectedHumid)
-		} else {
-			log.Printf("Read malformed data (less than 3 bytes): %v", readVal)
-		}
+	// Some sensors don't notify immediately upon subscribe, so try an
+	// explicit read too; whichever arrives first wins.
+	if readTemp, readHumid, readErr := session.ReadData(); readErr == nil {
+		collectedTemp = readTemp
+		collectedHumid = readHumid
+		dataReadyOnce.Do(func() { close(dataReady) }) // Signal that data is ready from the read
+		logx.Debugf(logx.BLE, "Read and parsed: T=%.2f, H=%d", readTemp, readHumid)
 	} else {
-		log.Printf("Failed to read characteristic %s: %v", dataCharacteristicUUID, readErr)
+		logx.Debugf(logx.BLE, "Failed to read sensor data for %s: %v", macAddress, readErr)
 	}
-	// --- END NEW ADDITION ---
 
 	select {
 	case <-dataReady:
-		log.Printf("Successfully received data: T=%.2f°C, H=%d%% from %s", collectedTemp, collectedHumid, macAddress)
-		return collectedTemp, collectedHumid, nil
+		logx.Infof("Successfully received data: T=%.2f°C, H=%d%% from %s", collectedTemp, collectedHumid, macAddress)
+		return collectedTemp, collectedHumid, battery, firmware, nil
 	case <-time.After(notificationWaitTimeoutSeconds):
-		log.Printf("Timeout waiting for data notification from %s after %s.", macAddress, notificationWaitTimeoutSeconds)
-		return 0, 0, fmt.Errorf("notification timeout")
+		logx.Warnf("Timeout waiting for data notification from %s after %s.", macAddress, notificationWaitTimeoutSeconds)
+		return 0, 0, nil, nil, fmt.Errorf("notification timeout")
+	}
+}
+
+// shouldRefreshBattery reports whether macAddress's last known battery
+// reading is missing or older than batteryCheckInterval.
+func shouldRefreshBattery(macAddress string) bool {
+	batteryCacheMu.Lock()
+	defer batteryCacheMu.Unlock()
+	entry, ok := batteryCache[macAddress]
+	return !ok || time.Since(entry.lastChecked) >= batteryCheckInterval
+}
+
+// refreshBatteryAndFirmware reads the standard Battery Service level and
+// Firmware Revision String characteristics over the already-connected
+// session and updates batteryCache, piggybacking on the connection already
+// open for the temperature/humidity read so it doesn't cost an extra
+// scan/connect cycle.
+func refreshBatteryAndFirmware(session sensor.Session, macAddress string) error {
+	level, firmware, err := session.ReadBattery()
+	if err != nil {
+		return err
+	}
+
+	entry := batteryCacheEntry{level: level, firmware: firmware, lastChecked: time.Now()}
+
+	batteryCacheMu.Lock()
+	batteryCache[macAddress] = entry
+	batteryCacheMu.Unlock()
+
+	logx.Infof("Refreshed battery for %s: %d%%, firmware %q", macAddress, entry.level, entry.firmware)
+	return nil
+}
+
+// lastKnownBattery returns the most recently cached battery/firmware reading
+// for macAddress, if any, so it can be surfaced in logs or on shutdown.
+func lastKnownBattery(macAddress string) (batteryCacheEntry, bool) {
+	batteryCacheMu.Lock()
+	defer batteryCacheMu.Unlock()
+	entry, ok := batteryCache[macAddress]
+	return entry, ok
+}
+
+// sleepOrDone waits for d or until ctx is cancelled, whichever comes first.
+// It reports whether ctx was cancelled, so callers can unwind instead of
+// starting another iteration of work that would just be cancelled anyway.
+func sleepOrDone(ctx context.Context, d time.Duration) (cancelled bool) {
+	select {
+	case <-time.After(d):
+		return false
+	case <-ctx.Done():
+		return true
 	}
 }
 
-// collectorLoop periodically collects sensor data.
-func collectorLoop(wg *sync.WaitGroup) {
+// collectorLoop periodically collects sensor data for a single device. Each
+// configured MAC address runs its own instance of this loop in its own
+// goroutine, with its own retry budget, so a slow or unreachable sensor
+// can't delay collection from the others. It exits as soon as ctx is
+// cancelled, aborting any in-flight attempt rather than leaking it.
+func collectorLoop(ctx context.Context, macAddress string, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for {
 		var temperature float64
 		var humidity int
+		var battery *int
+		var firmware *string
 		var collectionErr error
 
 		for attempt := 1; attempt <= maxCollectionRetries; attempt++ {
-			log.Printf("Collection attempt %d/%d for %s...", attempt, maxCollectionRetries, macToMonitor)
-			temperature, humidity, collectionErr = collectSingleReading(macToMonitor)
+			if ctx.Err() != nil {
+				logx.Infof("Shutting down collector for %s.", macAddress)
+				return
+			}
+			logx.Debugf(logx.Scan, "Collection attempt %d/%d for %s...", attempt, maxCollectionRetries, macAddress)
+			temperature, humidity, battery, firmware, collectionErr = collectSingleReading(ctx, macAddress)
+			recordCollectionResult(macAddress, collectionErr)
 
 			if collectionErr == nil {
-				storeSensorData(temperature, humidity)
+				storeSensorData(ctx, macAddress, temperature, humidity, battery, firmware)
 				break // Success, break retry loop
 			} else {
-				log.Printf("Collection failed for %s on attempt %d: %v. Retrying in %s...", macToMonitor, attempt, collectionErr, retryDelaySeconds)
-				time.Sleep(retryDelaySeconds)
+				logx.Warnf("Collection failed for %s on attempt %d: %v. Retrying in %s...", macAddress, attempt, collectionErr, retryDelaySeconds)
+				if sleepOrDone(ctx, retryDelaySeconds) {
+					logx.Infof("Shutting down collector for %s.", macAddress)
+					return
+				}
 			}
 		}
 
 		if collectionErr != nil {
-			log.Printf("Failed to collect data from %s after %d attempts. Will try again in the next interval.", macToMonitor, maxCollectionRetries)
+			logx.Warnf("Failed to collect data from %s after %d attempts. Will try again in the next interval.", macAddress, maxCollectionRetries)
 		}
 
-		log.Printf("Waiting for %s until next scheduled collection...", pollInterval)
-		time.Sleep(pollInterval)
+		logx.Debugf(logx.Scan, "Waiting for %s until next scheduled collection for %s...", pollInterval, macAddress)
+		if sleepOrDone(ctx, pollInterval) {
+			logx.Infof("Shutting down collector for %s.", macAddress)
+			return
+		}
 	}
 }
 
-// retentionLoop periodically applies the data retention policy.
-func retentionLoop(wg *sync.WaitGroup) {
+// retentionLoop periodically applies the data retention policy. It exits as
+// soon as ctx is cancelled.
+func retentionLoop(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// Run once immediately, then every 24 hours
-	applyRetentionPolicy()
+	applyRetentionPolicy(ctx)
+	logBatteryStatus()
 	ticker := time.NewTicker(24 * time.Hour)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		log.Println("Running daily data retention policy...")
-		applyRetentionPolicy()
+	for {
+		select {
+		case <-ticker.C:
+			logx.Debugf(logx.Retention, "Running daily data retention policy...")
+			applyRetentionPolicy(ctx)
+			logBatteryStatus()
+		case <-ctx.Done():
+			logx.Infof("Shutting down retention loop.")
+			return
+		}
+	}
+}
+
+// logBatteryStatus logs the last known battery level for every configured
+// device so operators can spot a dying sensor from the logs alone.
+func logBatteryStatus() {
+	for _, mac := range macAddresses {
+		entry, ok := lastKnownBattery(mac)
+		if !ok {
+			logx.Debugf(logx.Retention, "Battery status for %s: unknown (no successful read yet).", mac)
+			continue
+		}
+		logx.Debugf(logx.Retention, "Battery status for %s: %d%%, firmware %q, last checked %s.", mac, entry.level, entry.firmware, entry.lastChecked.Format("2006-01-02 15:04:05"))
 	}
 }
 
 func main() {
-	log.Println("Starting sensor data collector script...")
+	logx.Infof("Starting sensor data collector script...")
 
-	// Initialize BLE adapter once
-	d, err := linux.NewDevice()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize the BLE backend compiled into this binary once, shared by
+	// every per-device worker.
+	backend, err := sensor.New()
 	if err != nil {
-		log.Fatalf("Can't new device: %v", err)
+		log.Fatalf("Failed to initialize BLE backend: %v", err)
+	}
+	bleBackend = backend
+	defer bleBackend.Close() // Ensure the adapter is stopped on application exit, after every worker has returned
+
+	logx.Infof("Using BLE backend: %s", bleBackend.Name())
+	if configuredBackend != "" && !strings.EqualFold(configuredBackend, bleBackend.Name()) {
+		log.Fatalf("config.json requests backend %q but this binary was built with the %q backend", configuredBackend, bleBackend.Name())
 	}
-	ble.SetDefaultDevice(d)
-	defer d.Stop() // Ensure the device is stopped on application exit
 
 	setupDatabase()
 
+	workerCount := 1 + len(macAddresses) // One collectorLoop per device, plus retentionLoop
+	if metricsListen != "" {
+		workerCount++ // Plus metricsServerLoop
+	}
+
 	var wg sync.WaitGroup
-	wg.Add(2) // Two goroutines: collectorLoop and retentionLoop
+	wg.Add(workerCount)
 
-	go collectorLoop(&wg)
-	go retentionLoop(&wg)
+	for _, mac := range macAddresses {
+		go collectorLoop(ctx, mac, &wg)
+	}
+	go retentionLoop(ctx, &wg)
+	if metricsListen != "" {
+		go metricsServerLoop(ctx, metricsListen, &wg)
+	}
+
+	<-ctx.Done()
+	logx.Infof("Shutdown signal received, waiting for in-flight work to finish...")
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-	// Keep the main goroutine alive until interrupted
-	// In a real application, you might use a context for graceful shutdown.
-	// For this script, we'll wait indefinitely.
-	select {} // Block forever, or until process is killed (e.g., Ctrl+C)
+	select {
+	case <-done:
+		logx.Infof("Graceful shutdown complete.")
+	case <-time.After(shutdownGracePeriod):
+		logx.Warnf("Shutdown did not complete within %s; exiting forcefully.", shutdownGracePeriod)
+		os.Exit(1)
+	}
 }
 
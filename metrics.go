@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/syazwansaidan93/LYWSD03MMC_go/logx"
+)
+
+var (
+	temperatureGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lywsd03mmc_temperature_celsius",
+		Help: "Last temperature reading from a sensor, in degrees Celsius.",
+	}, []string{"mac"})
+
+	humidityGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lywsd03mmc_humidity_percent",
+		Help: "Last relative humidity reading from a sensor, as a percentage.",
+	}, []string{"mac"})
+
+	batteryGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lywsd03mmc_battery_percent",
+		Help: "Last known battery level of a sensor, as a percentage.",
+	}, []string{"mac"})
+
+	collectionAttemptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lywsd03mmc_collection_attempts_total",
+		Help: "Total number of collection attempts per sensor, by result.",
+	}, []string{"mac", "result"})
+
+	collectionDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lywsd03mmc_collection_duration_seconds",
+		Help:    "Duration of a single collection attempt (connect through data read), in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mac"})
+
+	sensorReadingsTotalGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lywsd03mmc_db_rows",
+		Help: "Current number of rows in the sensor_readings table.",
+	})
+
+	lastInsertAgeSecondsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lywsd03mmc_db_last_insert_age_seconds",
+		Help: "Seconds since the most recent successful insert into sensor_readings.",
+	})
+
+	retentionDeletesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lywsd03mmc_db_retention_deletes_total",
+		Help: "Total number of rows removed by the retention policy.",
+	})
+)
+
+// startCollectionTimer starts a timer for a single collection attempt
+// against mac and returns a function that records its duration when called,
+// mirroring the timer-around-operation pattern used for the DB stats below.
+func startCollectionTimer(mac string) func() {
+	start := time.Now()
+	return func() {
+		collectionDurationSeconds.WithLabelValues(mac).Observe(time.Since(start).Seconds())
+	}
+}
+
+// recordCollectionResult increments collectionAttemptsTotal for mac with
+// "success" or "failure" depending on err.
+func recordCollectionResult(mac string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	collectionAttemptsTotal.WithLabelValues(mac, result).Inc()
+}
+
+// updateDBStatsGauges refreshes the row count and last-insert-age gauges
+// from the database. It's called after every insert and retention sweep so
+// the exposed values stay close to real time without a dedicated poller.
+func updateDBStatsGauges(ctx context.Context, conn *sql.DB) {
+	var rowCount int64
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sensor_readings`).Scan(&rowCount); err != nil {
+		logx.Warnf("Error querying sensor_readings row count for metrics: %v", err)
+	} else {
+		sensorReadingsTotalGauge.Set(float64(rowCount))
+	}
+
+	var lastTimestamp sql.NullString
+	if err := conn.QueryRowContext(ctx, `SELECT MAX(timestamp) FROM sensor_readings`).Scan(&lastTimestamp); err != nil {
+		logx.Warnf("Error querying last insert time for metrics: %v", err)
+		return
+	}
+	if !lastTimestamp.Valid {
+		return
+	}
+	lastInsert, err := time.Parse("2006-01-02 15:04:05.000", lastTimestamp.String)
+	if err != nil {
+		logx.Warnf("Error parsing last insert time for metrics: %v", err)
+		return
+	}
+	lastInsertAgeSecondsGauge.Set(time.Since(lastInsert).Seconds())
+}
+
+// metricsServerLoop serves the Prometheus /metrics endpoint on addr until
+// ctx is cancelled, at which point it shuts down within shutdownGracePeriod.
+func metricsServerLoop(ctx context.Context, addr string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logx.Infof("Starting metrics server on %s...", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		logx.Infof("Shutting down metrics server.")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logx.Warnf("Error shutting down metrics server: %v", err)
+		}
+	case err := <-serverErr:
+		logx.Warnf("Metrics server error: %v", err)
+	}
+}
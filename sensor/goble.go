@@ -0,0 +1,139 @@
+//go:build !tinygo
+
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux" // Linux-specific adapter
+	"github.com/syazwansaidan93/LYWSD03MMC_go/logx"
+)
+
+// connectMu serializes ble.Connect calls across all callers. HCI on Linux is
+// single-tenant: only one scan/connect can be in flight against the shared
+// adapter at a time.
+var connectMu sync.Mutex
+
+// goBleBackend is the default Backend, wrapping github.com/go-ble/ble on top
+// of the Linux BlueZ HCI adapter.
+type goBleBackend struct {
+	device *linux.Device
+}
+
+// New creates the go-ble backend and initializes the shared Linux BLE adapter.
+func New() (Backend, error) {
+	d, err := linux.NewDevice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize BLE device: %w", err)
+	}
+	ble.SetDefaultDevice(d)
+	return &goBleBackend{device: d}, nil
+}
+
+func (b *goBleBackend) Name() string {
+	return "go-ble"
+}
+
+func (b *goBleBackend) Connect(ctx context.Context, mac string) (Session, error) {
+	macAddress := strings.ToUpper(mac)
+
+	connectMu.Lock()
+	cln, err := ble.Connect(ctx, func(a ble.Advertisement) bool {
+		return strings.ToUpper(a.Addr().String()) == macAddress
+	})
+	connectMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", macAddress, err)
+	}
+
+	profile, err := cln.DiscoverProfile(true)
+	if err != nil {
+		cln.CancelConnection()
+		return nil, fmt.Errorf("failed to discover profile for %s: %w", macAddress, err)
+	}
+
+	return &goBleSession{mac: macAddress, client: cln, profile: profile}, nil
+}
+
+func (b *goBleBackend) Close() error {
+	return b.device.Stop()
+}
+
+// goBleSession is a single connected go-ble session with one sensor.
+type goBleSession struct {
+	mac     string
+	client  ble.Client
+	profile *ble.Profile
+}
+
+func (s *goBleSession) findCharacteristic(uuidStr string) *ble.Characteristic {
+	target := ble.MustParse(uuidStr)
+	for _, svc := range s.profile.Services {
+		for _, c := range svc.Characteristics {
+			if c.UUID.Equal(target) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (s *goBleSession) ReadData() (float64, int, error) {
+	dataChar := s.findCharacteristic(DataCharacteristicUUID)
+	if dataChar == nil {
+		return 0, 0, fmt.Errorf("data characteristic %s not found", DataCharacteristicUUID)
+	}
+	val, err := s.client.ReadCharacteristic(dataChar)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read data characteristic: %w", err)
+	}
+	logx.Debugf(logx.BLE, "ReadCharacteristic %s for %s: % x", DataCharacteristicUUID, s.mac, val)
+	return decodeTempHumidity(val)
+}
+
+func (s *goBleSession) SubscribeData(handler func(temperature float64, humidity int)) error {
+	dataChar := s.findCharacteristic(DataCharacteristicUUID)
+	if dataChar == nil {
+		return fmt.Errorf("data characteristic %s not found", DataCharacteristicUUID)
+	}
+	return s.client.Subscribe(dataChar, true, func(data []byte) {
+		logx.Debugf(logx.Notify, "Raw notification from %s: % x", s.mac, data)
+		temperature, humidity, err := decodeTempHumidity(data)
+		if err != nil {
+			logx.Debugf(logx.Notify, "Malformed notification from %s: %v", s.mac, err)
+			return
+		}
+		handler(temperature, humidity)
+	})
+}
+
+func (s *goBleSession) ReadBattery() (int, string, error) {
+	batteryChar := s.findCharacteristic(BatteryCharacteristicUUID)
+	if batteryChar == nil {
+		return 0, "", fmt.Errorf("battery characteristic %s not found", BatteryCharacteristicUUID)
+	}
+	val, err := s.client.ReadCharacteristic(batteryChar)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read battery characteristic: %w", err)
+	}
+	if len(val) < 1 {
+		return 0, "", fmt.Errorf("battery characteristic returned no data")
+	}
+	level := int(val[0])
+
+	var firmware string
+	if firmwareChar := s.findCharacteristic(FirmwareCharacteristicUUID); firmwareChar != nil {
+		if fwVal, err := s.client.ReadCharacteristic(firmwareChar); err == nil {
+			firmware = strings.TrimRight(string(fwVal), "\x00")
+		}
+	}
+	return level, firmware, nil
+}
+
+func (s *goBleSession) Close() error {
+	return s.client.CancelConnection()
+}
@@ -0,0 +1,57 @@
+// Package sensor abstracts the BLE transport used to talk to an LYWSD03MMC
+// sensor behind a small Backend/Session interface, so the collector loop in
+// main doesn't care whether readings come from go-ble or tinygo/bluetooth.
+// Exactly one backend is compiled in at a time, selected by build tag (see
+// goble.go and tinygo.go); New returns whichever one that build contains.
+package sensor
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// Characteristic UUIDs shared by both backend implementations.
+const (
+	DataCharacteristicUUID     = "ebe0ccc1-7a0a-4b0c-8a1a-6ff2997da3a6"
+	BatteryCharacteristicUUID  = "00002a19-0000-1000-8000-00805f9b34fb"
+	FirmwareCharacteristicUUID = "00002a26-0000-1000-8000-00805f9b34fb"
+)
+
+// Backend connects to LYWSD03MMC sensors over a particular BLE transport.
+// Implementations must serialize their own Connect calls if the underlying
+// adapter can only service one scan/connect at a time.
+type Backend interface {
+	// Name identifies the backend, e.g. "go-ble" or "tinygo". It is used to
+	// sanity-check config.json's "backend" field against the binary actually
+	// running.
+	Name() string
+	// Connect scans for and connects to mac, honoring ctx's deadline.
+	Connect(ctx context.Context, mac string) (Session, error)
+	// Close stops the underlying adapter. Call once, after every Session
+	// returned by Connect has been closed.
+	Close() error
+}
+
+// Session is a single connected session with one sensor.
+type Session interface {
+	// ReadData performs a one-shot read of the temperature/humidity characteristic.
+	ReadData() (temperature float64, humidity int, err error)
+	// SubscribeData registers handler to be called on every temperature/humidity notification.
+	SubscribeData(handler func(temperature float64, humidity int)) error
+	// ReadBattery reads the standard Battery Service level and firmware revision string.
+	ReadBattery() (level int, firmware string, err error)
+	// Close disconnects the session.
+	Close() error
+}
+
+// decodeTempHumidity parses the LYWSD03MMC's custom notify/read payload:
+// 2 bytes little-endian signed centidegrees Celsius, then 1 byte humidity percent.
+func decodeTempHumidity(data []byte) (temperature float64, humidity int, err error) {
+	if len(data) < 3 {
+		return 0, 0, fmt.Errorf("malformed sensor payload (want at least 3 bytes, got %d): %x", len(data), data)
+	}
+	temperature = float64(int16(binary.LittleEndian.Uint16(data[0:2]))) / 100.0
+	humidity = int(data[2])
+	return temperature, humidity, nil
+}
@@ -0,0 +1,171 @@
+//go:build tinygo
+
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/syazwansaidan93/LYWSD03MMC_go/logx"
+	"tinygo.org/x/bluetooth"
+)
+
+// connectMu serializes scan/connect calls across all callers. HCI on Linux
+// is single-tenant: only one scan/connect can be in flight against the
+// shared adapter at a time.
+var connectMu sync.Mutex
+
+// tinygoBackend wraps tinygo.org/x/bluetooth, an alternative to go-ble that
+// isn't limited to Linux+BlueZ.
+type tinygoBackend struct {
+	adapter *bluetooth.Adapter
+}
+
+// New creates the tinygo backend and enables the default adapter.
+func New() (Backend, error) {
+	adapter := bluetooth.DefaultAdapter
+	if err := adapter.Enable(); err != nil {
+		return nil, fmt.Errorf("failed to enable BLE adapter: %w", err)
+	}
+	return &tinygoBackend{adapter: adapter}, nil
+}
+
+func (b *tinygoBackend) Name() string {
+	return "tinygo"
+}
+
+func (b *tinygoBackend) Connect(ctx context.Context, mac string) (Session, error) {
+	macAddress := strings.ToUpper(mac)
+
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	device, err := b.scanAndConnect(ctx, macAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := device.DiscoverServices(nil)
+	if err != nil {
+		device.Disconnect()
+		return nil, fmt.Errorf("failed to discover services for %s: %w", macAddress, err)
+	}
+
+	return &tinygoSession{mac: macAddress, device: device, services: services}, nil
+}
+
+// scanAndConnect scans until a device matching macAddress is found and
+// connects to it, aborting the scan if ctx is cancelled first.
+func (b *tinygoBackend) scanAndConnect(ctx context.Context, macAddress string) (bluetooth.Device, error) {
+	found := make(chan bluetooth.Address, 1)
+	scanErr := make(chan error, 1)
+
+	go func() {
+		err := b.adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+			if strings.ToUpper(result.Address.String()) == macAddress {
+				adapter.StopScan()
+				found <- result.Address
+			}
+		})
+		if err != nil {
+			scanErr <- err
+		}
+	}()
+
+	select {
+	case addr := <-found:
+		return b.adapter.Connect(addr, bluetooth.ConnectionParams{})
+	case err := <-scanErr:
+		return bluetooth.Device{}, fmt.Errorf("scan failed: %w", err)
+	case <-ctx.Done():
+		b.adapter.StopScan()
+		return bluetooth.Device{}, fmt.Errorf("scan for %s: %w", macAddress, ctx.Err())
+	}
+}
+
+func (b *tinygoBackend) Close() error {
+	return nil
+}
+
+// tinygoSession is a single connected tinygo session with one sensor.
+type tinygoSession struct {
+	mac      string
+	device   bluetooth.Device
+	services []bluetooth.DeviceService
+}
+
+func (s *tinygoSession) findCharacteristic(uuidStr string) (bluetooth.DeviceCharacteristic, bool) {
+	target, err := bluetooth.ParseUUID(uuidStr)
+	if err != nil {
+		return bluetooth.DeviceCharacteristic{}, false
+	}
+	for _, svc := range s.services {
+		chars, err := svc.DiscoverCharacteristics([]bluetooth.UUID{target})
+		if err != nil || len(chars) == 0 {
+			continue
+		}
+		return chars[0], true
+	}
+	return bluetooth.DeviceCharacteristic{}, false
+}
+
+func (s *tinygoSession) ReadData() (float64, int, error) {
+	char, ok := s.findCharacteristic(DataCharacteristicUUID)
+	if !ok {
+		return 0, 0, fmt.Errorf("data characteristic %s not found", DataCharacteristicUUID)
+	}
+	buf := make([]byte, 8)
+	n, err := char.Read(buf)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read data characteristic: %w", err)
+	}
+	logx.Debugf(logx.BLE, "ReadCharacteristic %s for %s: % x", DataCharacteristicUUID, s.mac, buf[:n])
+	return decodeTempHumidity(buf[:n])
+}
+
+func (s *tinygoSession) SubscribeData(handler func(temperature float64, humidity int)) error {
+	char, ok := s.findCharacteristic(DataCharacteristicUUID)
+	if !ok {
+		return fmt.Errorf("data characteristic %s not found", DataCharacteristicUUID)
+	}
+	return char.EnableNotifications(func(buf []byte) {
+		logx.Debugf(logx.Notify, "Raw notification from %s: % x", s.mac, buf)
+		temperature, humidity, err := decodeTempHumidity(buf)
+		if err != nil {
+			logx.Debugf(logx.Notify, "Malformed notification from %s: %v", s.mac, err)
+			return
+		}
+		handler(temperature, humidity)
+	})
+}
+
+func (s *tinygoSession) ReadBattery() (int, string, error) {
+	batteryChar, ok := s.findCharacteristic(BatteryCharacteristicUUID)
+	if !ok {
+		return 0, "", fmt.Errorf("battery characteristic %s not found", BatteryCharacteristicUUID)
+	}
+	buf := make([]byte, 4)
+	n, err := batteryChar.Read(buf)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read battery characteristic: %w", err)
+	}
+	if n < 1 {
+		return 0, "", fmt.Errorf("battery characteristic returned no data")
+	}
+	level := int(buf[0])
+
+	var firmware string
+	if firmwareChar, ok := s.findCharacteristic(FirmwareCharacteristicUUID); ok {
+		fwBuf := make([]byte, 32)
+		if fn, err := firmwareChar.Read(fwBuf); err == nil {
+			firmware = strings.TrimRight(string(fwBuf[:fn]), "\x00")
+		}
+	}
+	return level, firmware, nil
+}
+
+func (s *tinygoSession) Close() error {
+	return s.device.Disconnect()
+}